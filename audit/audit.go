@@ -0,0 +1,88 @@
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+const defaultBuffer = 256
+
+// Events recorded by the encoder and player
+const (
+	// Served is recorded by an encoder once it has served the final block of a stream, or is closed partway through
+	Served = "served"
+	// Verified is recorded by a player once every block of a stream has been verified
+	Verified = "verified"
+	// VerificationFailed is recorded by a player when a block fails verification, or the stream ends before its final block
+	VerificationFailed = "verification_failed"
+)
+
+// Event is a single JSON line of the audit log
+type Event struct {
+	Time        time.Time `json:"time"`
+	Event       string    `json:"event"`
+	Stream      string    `json:"stream"`
+	InitialHash string    `json:"initial_hash,omitempty"`
+	// StartBlock and Anchor are set for streams that started past the first block,
+	// Anchor being the hash the stream was verified from
+	StartBlock int64  `json:"start_block,omitempty"`
+	Anchor     string `json:"anchor,omitempty"`
+	// Bytes counts wire bytes for Served events, including hashes and frame headers,
+	// and decoded plaintext bytes for Verified and VerificationFailed events
+	Bytes int64 `json:"bytes"`
+	// Partial is set on Served events for streams closed before their final block was served
+	Partial bool `json:"partial,omitempty"`
+	// Block is the index of the block that failed verification
+	Block *int64 `json:"block,omitempty"`
+	// Error is why verification failed, or the first failed request of a Served stream
+	Error string `json:"error,omitempty"`
+}
+
+// Log writes Events to an io.Writer as JSON lines from its own goroutine, so recording doesn't wait on the writer.
+type Log struct {
+	events chan Event
+	done   chan struct{}
+	err    error
+}
+
+// NewLog starts a Log writing to w, holding up to buffer pending Events before Record has to wait.
+// A buffer <= 0 uses a default size.
+func NewLog(w io.Writer, buffer int) *Log {
+	if buffer <= 0 {
+		buffer = defaultBuffer
+	}
+	l := &Log{
+		events: make(chan Event, buffer),
+		done:   make(chan struct{}),
+	}
+	go l.run(w)
+	return l
+}
+
+func (l *Log) run(w io.Writer) {
+	defer close(l.done)
+	enc := json.NewEncoder(w)
+	for e := range l.events {
+		// keep draining after a failed write so Record never blocks forever, only the first error is kept
+		if err := enc.Encode(e); err != nil && l.err == nil {
+			l.err = err
+		}
+	}
+}
+
+// Record queues e to be written, stamping its Time if unset.
+// It only blocks when the buffer is full. Record must not be called after Close.
+func (l *Log) Record(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	l.events <- e
+}
+
+// Close writes any pending Events and returns the first error from the writer.
+func (l *Log) Close() error {
+	close(l.events)
+	<-l.done
+	return l.err
+}
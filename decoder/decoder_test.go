@@ -1 +1,337 @@
 package decoder
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// encode builds hashed blocks the same way the encoder does, without touching the filesystem.
+// It returns the initial hash along with each hashedBlock in request order.
+func encode(data []byte, blockSize int) (initialHash []byte, hashedBlocks [][]byte) {
+	numBlocks := (len(data)-1)/blockSize + 1
+	hashedBlocks = make([][]byte, numBlocks)
+	parentHash := make([]byte, 32)
+	for i := numBlocks - 1; i >= 0; i-- {
+		end := (i + 1) * blockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		hashedBlock := append(append([]byte{}, data[i*blockSize:end]...), parentHash...)
+		hashedBlocks[i] = hashedBlock
+		sum := sha256.Sum256(hashedBlock)
+		parentHash = sum[:]
+	}
+	return parentHash, hashedBlocks
+}
+
+func testData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	return data
+}
+
+func TestStreamResume(t *testing.T) {
+	data := testData(10*1024 + 512)
+	initialHash, hashedBlocks := encode(data, 1024)
+	handoff := len(hashedBlocks) / 2
+
+	var out bytes.Buffer
+
+	s, err := NewStream(initialHash)
+	if err != nil {
+		t.Fatalf("failed creating stream: %v", err)
+	}
+	for i := 0; i < handoff; i++ {
+		block, err := s.Next(hashedBlocks[i])
+		if err != nil {
+			t.Fatalf("failed on block %d: %v", i, err)
+		}
+		out.Write(block)
+	}
+
+	// snapshot the chain position and continue in a second Stream
+	hash := s.Hash()
+	if !bytes.Equal(hash, hashedBlocks[handoff-1][len(hashedBlocks[handoff-1])-32:]) {
+		t.Fatalf("handoff hash does not match trailing hash of block %d, got: %v", handoff-1, hash)
+	}
+
+	resumed, err := NewStream(hash)
+	if err != nil {
+		t.Fatalf("failed resuming stream: %v", err)
+	}
+	for i := handoff; i < len(hashedBlocks); i++ {
+		block, err := resumed.Next(hashedBlocks[i])
+		if err != nil {
+			t.Fatalf("failed on resumed block %d: %v", i, err)
+		}
+		out.Write(block)
+	}
+
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatalf("decoded data does not match original")
+	}
+	if !bytes.Equal(resumed.Hash(), make([]byte, 32)) {
+		t.Fatalf("expected trailing 0-hash, got: %v", resumed.Hash())
+	}
+	if got, want := s.Blocks()+resumed.Blocks(), int64(len(hashedBlocks)); got != want {
+		t.Fatalf("expected %d verified blocks, got: %d", want, got)
+	}
+
+	// the original stream is unaffected by the handoff and rejects out of order blocks
+	if _, err := s.Next(hashedBlocks[handoff+1]); err == nil {
+		t.Fatalf("expected verification failure for out of order block")
+	}
+	if !bytes.Equal(s.Hash(), hash) {
+		t.Fatalf("failed block should not move the chain position")
+	}
+}
+
+// patternReader produces size deterministic bytes without allocating them up front
+type patternReader struct {
+	seed, off, size int64
+	maxRead         int
+}
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	if r.off >= r.size {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.size-r.off {
+		p = p[:r.size-r.off]
+	}
+	if len(p) > r.maxRead {
+		r.maxRead = len(p)
+	}
+	for i := range p {
+		p[i] = byte((r.off + int64(i)) * r.seed)
+	}
+	r.off += int64(len(p))
+	return len(p), nil
+}
+
+func frameHeader(size int64) io.Reader {
+	header := make([]byte, FrameHeaderSize)
+	binary.BigEndian.PutUint64(header, uint64(size))
+	return bytes.NewReader(header)
+}
+
+func TestStreamFrames(t *testing.T) {
+	const blockSize = 16 << 20
+	sizes := []int64{blockSize, blockSize, blockSize, 3<<20 + 7}
+
+	// hash from the highest block down, streaming each payload through the hash
+	hashes := make([][]byte, len(sizes)+1)
+	hashes[len(sizes)] = make([]byte, 32)
+	for i := len(sizes) - 1; i >= 0; i-- {
+		h := sha256.New()
+		io.Copy(h, &patternReader{seed: int64(i + 3), size: sizes[i]})
+		h.Write(hashes[i+1])
+		hashes[i] = h.Sum(nil)
+	}
+
+	s, err := NewStream(hashes[0])
+	if err != nil {
+		t.Fatalf("failed creating stream: %v", err)
+	}
+	for i, size := range sizes {
+		payload := &patternReader{seed: int64(i + 3), size: size}
+		frame := io.MultiReader(frameHeader(size), payload, bytes.NewReader(hashes[i+1]))
+
+		out := sha256.New()
+		n, err := s.NextFrame(frame, out)
+		if err != nil {
+			t.Fatalf("failed on frame %d: %v", i, err)
+		}
+		if n != size {
+			t.Fatalf("frame %d expected %d bytes, got: %d", i, size, n)
+		}
+		if payload.maxRead >= blockSize {
+			t.Fatalf("frame %d was read in a single %d byte chunk", i, payload.maxRead)
+		}
+
+		want := sha256.New()
+		io.Copy(want, &patternReader{seed: int64(i + 3), size: size})
+		if !bytes.Equal(out.Sum(nil), want.Sum(nil)) {
+			t.Fatalf("frame %d payload does not match", i)
+		}
+		if !bytes.Equal(s.Hash(), hashes[i+1]) {
+			t.Fatalf("frame %d expected next hash %v, got: %v", i, hashes[i+1], s.Hash())
+		}
+	}
+
+	// a tampered payload fails once the trailing hash is read
+	s, _ = NewStream(hashes[0])
+	tampered := &patternReader{seed: 99, size: sizes[0]}
+	if _, err := s.NextFrame(io.MultiReader(frameHeader(sizes[0]), tampered, bytes.NewReader(hashes[1])), io.Discard); err == nil {
+		t.Fatalf("expected verification failure for tampered frame")
+	}
+
+	// a short anchor is rejected rather than indexed past its end
+	frame := io.MultiReader(frameHeader(sizes[3]), &patternReader{seed: 6, size: sizes[3]}, bytes.NewReader(hashes[4]))
+	if _, _, err := DecodeFrame(hashes[3][:16], frame, io.Discard); err == nil {
+		t.Fatalf("expected error for a short frame hash")
+	}
+
+	// a frame cut short of its declared length is rejected
+	short := &patternReader{seed: 3, size: sizes[0] / 2}
+	if _, err := s.NextFrame(io.MultiReader(frameHeader(sizes[0]), short), io.Discard); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected %v for truncated frame, got: %v", io.ErrUnexpectedEOF, err)
+	}
+}
+
+// readSizes records the largest single read from a reader
+type readSizes struct {
+	r       io.Reader
+	maxRead int
+}
+
+func (r *readSizes) Read(p []byte) (int, error) {
+	if len(p) > r.maxRead {
+		r.maxRead = len(p)
+	}
+	return r.r.Read(p)
+}
+
+func TestAuditHashReader(t *testing.T) {
+	const numBlocks = 100000
+	const blockSize = 256
+	payload := func(i int64) []byte {
+		b := make([]byte, blockSize)
+		(&patternReader{seed: i + 1, size: blockSize}).Read(b)
+		return b
+	}
+
+	// build the packed hash list from the highest block down without holding the list in memory
+	packed, err := os.CreateTemp(t.TempDir(), "hashes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer packed.Close()
+	parentHash := make([]byte, 32)
+	for i := int64(numBlocks - 1); i >= 0; i-- {
+		sum := sha256.Sum256(append(payload(i), parentHash...))
+		parentHash = sum[:]
+		if _, err := packed.WriteAt(parentHash, i*32); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// blocks are generated as they're requested, with the hash of the following block read from the packed file
+	stream := func(tamper int64) func() ([]byte, error) {
+		var i int64
+		return func() ([]byte, error) {
+			if i >= numBlocks {
+				return nil, io.EOF
+			}
+			nextHash := make([]byte, 32)
+			if i < numBlocks-1 {
+				if _, err := packed.ReadAt(nextHash, (i+1)*32); err != nil {
+					return nil, err
+				}
+			}
+			hashedBlock := append(payload(i), nextHash...)
+			if i == tamper {
+				hashedBlock[0] ^= 1
+			}
+			i++
+			return hashedBlock, nil
+		}
+	}
+
+	audit := func(tamper int64) (int64, int, error) {
+		if _, err := packed.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		r := &readSizes{r: packed}
+		blocks, err := Audit(NewHashReader(r), stream(tamper))
+		return blocks, r.maxRead, err
+	}
+
+	blocks, maxRead, err := audit(-1)
+	if err != nil {
+		t.Fatalf("failed auditing: %v", err)
+	}
+	if blocks != numBlocks {
+		t.Fatalf("expected %d audited blocks, got: %d", numBlocks, blocks)
+	}
+	if maxRead > 32 {
+		t.Fatalf("expected the hash list to be read one hash at a time, got a %d byte read", maxRead)
+	}
+
+	blocks, _, err = audit(numBlocks / 2)
+	if err == nil {
+		t.Fatalf("expected audit to fail on tampered block")
+	}
+	if blocks != numBlocks/2 {
+		t.Fatalf("expected audit to stop at block %d, got: %d", numBlocks/2, blocks)
+	}
+
+	// a list that ends early doesn't line up with the stream
+	if _, err := packed.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	short := NewHashReader(io.LimitReader(packed, 10*32))
+	if _, err := Audit(short, stream(-1)); err == nil {
+		t.Fatalf("expected audit to fail against a truncated hash list")
+	}
+}
+
+// sliceList is a HashList over hashes already in memory
+type sliceList [][]byte
+
+func (l *sliceList) Next() ([]byte, error) {
+	if len(*l) == 0 {
+		return nil, io.EOF
+	}
+	hash := (*l)[0]
+	*l = (*l)[1:]
+	return hash, nil
+}
+
+func TestAuditShortHash(t *testing.T) {
+	initialHash, hashedBlocks := encode(testData(5*1024), 1024)
+	list := func() [][]byte {
+		hashes := [][]byte{initialHash}
+		for _, hashedBlock := range hashedBlocks[:len(hashedBlocks)-1] {
+			hashes = append(hashes, hashedBlock[len(hashedBlock)-32:])
+		}
+		return hashes
+	}
+	stream := func() func() ([]byte, error) {
+		i := 0
+		return func() ([]byte, error) {
+			if i >= len(hashedBlocks) {
+				return nil, io.EOF
+			}
+			i++
+			return hashedBlocks[i-1], nil
+		}
+	}
+
+	hashes := sliceList(list())
+	if blocks, err := Audit(&hashes, stream()); err != nil || blocks != int64(len(hashedBlocks)) {
+		t.Fatalf("expected %d audited blocks, got %d: %v", len(hashedBlocks), blocks, err)
+	}
+
+	// a truncated list entry keeps a correct prefix, and is rejected rather than indexed past its end
+	for _, short := range []int{0, 2} {
+		hashes := sliceList(list())
+		hashes[short] = hashes[short][:16]
+		blocks, err := Audit(&hashes, stream())
+		if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("block %d:", short)) {
+			t.Fatalf("expected the short hash of block %d to be rejected, got: %v", short, err)
+		}
+		if blocks > int64(short) {
+			t.Fatalf("expected audit to stop by block %d, got: %d", short, blocks)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package decoder
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// FrameHeaderSize is the length of the big-endian uint64 payload length that prefixes every frame.
+// A frame is laid out as: payload length, payload, 32-byte hash of the next block.
+// encoder.Encoder.RequestFrame writes the same layout.
+const FrameHeaderSize = 8
+
+// DecodeFrame is the streaming form of Decode for a single length-prefixed frame.
+// The payload is hashed as it is copied to w, so a block is never held in memory as a whole.
+// Bytes reach w before the trailing hash is read, so when err != nil whatever was written to w is unverified and
+// must be discarded by the caller.
+// It returns the number of payload bytes written to w.
+func DecodeFrame(hash []byte, frame io.Reader, w io.Writer) (nextHash []byte, n int64, err error) {
+	if len(hash) != 32 {
+		return nil, 0, fmt.Errorf("Invalid frame hash, expected length 32, got: %v", len(hash))
+	}
+
+	header := make([]byte, FrameHeaderSize)
+	if _, err = io.ReadFull(frame, header); err != nil {
+		return nil, 0, fmt.Errorf("Failed reading frame header: %w", err)
+	}
+	payloadSize := binary.BigEndian.Uint64(header)
+	if payloadSize == 0 || payloadSize > 1<<62 {
+		return nil, 0, fmt.Errorf("Invalid frame payload length: %v", payloadSize)
+	}
+
+	h := sha256.New()
+	n, err = io.CopyN(io.MultiWriter(h, w), frame, int64(payloadSize))
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, n, fmt.Errorf("Failed reading frame payload after %d bytes: %w", n, err)
+	}
+
+	nextHash = make([]byte, 32)
+	if _, err = io.ReadFull(frame, nextHash); err != nil {
+		return nil, n, fmt.Errorf("Failed reading frame hash: %w", err)
+	}
+	h.Write(nextHash)
+	clientHash := h.Sum(nil)
+
+	for i := 0; i < 32; i++ {
+		if clientHash[i] != hash[i] {
+			return nil, n, fmt.Errorf("Hashed frame failed verification, expected: %v, got: %v", hash, clientHash)
+		}
+	}
+
+	return nextHash, n, nil
+}
@@ -0,0 +1,91 @@
+package decoder
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// HashList yields the expected hash of each block of a stream in order, one at a time.
+// Next returns io.EOF once every hash has been read.
+type HashList interface {
+	Next() ([]byte, error)
+}
+
+// HashReader is a HashList reading a packed file of back to back 32-byte hashes.
+// Only one hash is held in memory at a time, no matter how long the list is.
+type HashReader struct {
+	r io.Reader
+}
+
+// NewHashReader returns a HashReader reading packed hashes from r
+func NewHashReader(r io.Reader) *HashReader {
+	return &HashReader{r: r}
+}
+
+// Next returns the next hash in the list
+func (h *HashReader) Next() ([]byte, error) {
+	hash := make([]byte, 32)
+	_, err := io.ReadFull(h.r, hash)
+	if err == io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("Packed hash list is truncated mid-hash")
+	} else if err != nil {
+		return nil, err
+	}
+	return hash, nil
+}
+
+// Audit verifies every block returned by next against its expected hash from hashes, block by block,
+// rather than trusting the hashes carried within the stream.
+// The hash appended to each block must also match the next entry of the list, and the final 0-hash must
+// line up with the end of the list. Only the current and following hash are held in memory.
+// next should return hashed blocks in request order, and io.EOF after the final block.
+// It returns the number of blocks verified.
+func Audit(hashes HashList, next func() ([]byte, error)) (blocks int64, err error) {
+	expected, err := hashes.Next()
+	if err != nil {
+		return 0, fmt.Errorf("Failed reading the first hash: %w", err)
+	}
+	if len(expected) != 32 {
+		return 0, fmt.Errorf("block 0: Invalid listed hash, expected length 32, got: %v", len(expected))
+	}
+
+	for ; ; blocks++ {
+		following, listErr := hashes.Next()
+		if listErr == io.EOF {
+			// the final block ends the stream with the 0-hash
+			following = make([]byte, 32)
+		} else if listErr != nil {
+			return blocks, listErr
+		}
+		if len(following) != 32 {
+			return blocks, fmt.Errorf("block %d: Invalid listed hash, expected length 32, got: %v", blocks+1, len(following))
+		}
+
+		hashedBlock, err := next()
+		if err == io.EOF {
+			return blocks, fmt.Errorf("Stream ended after %d blocks, before the hash list", blocks)
+		} else if err != nil {
+			return blocks, err
+		}
+
+		_, nextHash, err := Decode(expected, hashedBlock)
+		if err != nil {
+			return blocks, fmt.Errorf("block %d: %w", blocks, err)
+		}
+		if !bytes.Equal(nextHash, following) {
+			return blocks, fmt.Errorf("block %d: Hashed block does not link to the next listed hash, expected: %v, got: %v", blocks, following, nextHash)
+		}
+
+		if listErr == io.EOF {
+			blocks++
+			if _, err = next(); err == nil {
+				return blocks, fmt.Errorf("Stream continues after the %d listed blocks", blocks)
+			} else if err != io.EOF {
+				return blocks, err
+			}
+			return blocks, nil
+		}
+		expected = following
+	}
+}
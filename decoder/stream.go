@@ -0,0 +1,61 @@
+package decoder
+
+import (
+	"fmt"
+	"io"
+)
+
+// Stream decodes a sequence of hashed blocks, keeping track of the hash that anchors the next block.
+// A Stream can be handed off by reading Hash and passing it to NewStream in another process.
+type Stream struct {
+	nextHash []byte
+	blocks   int64
+}
+
+// NewStream returns a Stream that will verify its first block against hash.
+// hash is either the initial hash of a stream, or the Hash of a previous Stream to resume from.
+func NewStream(hash []byte) (*Stream, error) {
+	if len(hash) != 32 {
+		return nil, fmt.Errorf("Invalid stream hash, expected length 32, got: %v", len(hash))
+	}
+	s := &Stream{nextHash: make([]byte, 32)}
+	copy(s.nextHash, hash)
+	return s, nil
+}
+
+// Next verifies hashedBlock against the current chain position and returns the decoded block.
+// On failure the chain position is left unchanged.
+func (s *Stream) Next(hashedBlock []byte) (block []byte, err error) {
+	block, nextHash, err := Decode(s.nextHash, hashedBlock)
+	if err != nil {
+		return nil, err
+	}
+	copy(s.nextHash, nextHash)
+	s.blocks++
+	return block, nil
+}
+
+// Hash returns a copy of the hash that anchors the next block in the stream.
+// After the final block this is the 32-byte 0-hash.
+func (s *Stream) Hash() []byte {
+	hash := make([]byte, 32)
+	copy(hash, s.nextHash)
+	return hash
+}
+
+// Blocks returns the number of blocks this Stream has verified.
+func (s *Stream) Blocks() int64 {
+	return s.blocks
+}
+
+// NextFrame verifies a length-prefixed frame against the current chain position, streaming its payload to w.
+// See DecodeFrame for the frame layout and the handling of unverified bytes on failure.
+func (s *Stream) NextFrame(frame io.Reader, w io.Writer) (n int64, err error) {
+	nextHash, n, err := DecodeFrame(s.nextHash, frame, w)
+	if err != nil {
+		return n, err
+	}
+	copy(s.nextHash, nextHash)
+	s.blocks++
+	return n, nil
+}
@@ -4,27 +4,82 @@
 package encoder
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"path"
 	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"stealthybox.dev/go-hash-player/audit"
+	"stealthybox.dev/go-hash-player/decoder"
 )
 
 const defaultBlockSize = 1024
 
+const defaultCacheRoot = "cache"
+
+// tempSeq is combined with the pid to keep deterministic temp names unique within and across runs
+var tempSeq int64
+
+// staleBuildAge is how long a cache build can go untouched before it's considered abandoned
+const staleBuildAge = time.Hour
+
+// rename is swapped out by tests to inject failures while writing the cache
+var rename = os.Rename
+
+// ProgressUpdate reports how many of a file's blocks have been hashed
+type ProgressUpdate struct {
+	Done  int64
+	Total int64
+}
+
 // Encoder represents a single chunkable stream of a file.
 // It will automatically open its file on the first Request.
 type Encoder struct {
 	FileName  string
 	BlockSize int64
+	// CacheRoot is the directory holding every file's cache, defaults to "cache"
+	CacheRoot string
+	// TempDir is where cache files are written before being moved into place, defaults to the directory the cache is built in.
+	// Files are moved with a rename, so TempDir must be on the same filesystem as CacheRoot. PreProcess checks this up front.
+	TempDir string
+	// DeterministicTempNames names temp files "<cacheKey>-<pid>-<seq><suffix>" instead of using random names,
+	// which makes leftover files traceable back to their cache
+	DeterministicTempNames bool
+	// Progress is called after each block is hashed during PreProcess
+	Progress func(done, total int64)
+	// ProgressCh receives the same updates as Progress for select-based consumers.
+	// Sends never block PreProcess: an update is dropped if the channel isn't ready to receive it,
+	// so a slow consumer may miss intermediate updates and the final one.
+	// The channel is not closed, PreProcess returning marks completion.
+	ProgressCh chan<- ProgressUpdate
+	// Store deduplicates block payloads across Encoders. When set, Requests serve blocks out of the Store
+	// rather than the file, see BlockStore for the cost of doing so.
+	Store *BlockStore
+	// Audit records an audit.Served event once the final block has been served by Request or RequestFrame,
+	// or on Close for a stream that stopped short of it
+	Audit *audit.Log
+	// StreamID identifies the stream in the Audit log, defaults to FileName
+	StreamID string
 
 	cacheKey         string
 	file             *os.File
 	numBlocks        int64
 	highestBlockSize int64
+	// hashes holds hashes loaded into memory by Warm or WarmRange, keyed by block index
+	hashes map[int64][]byte
+	// served counts the bytes returned by Request and RequestFrame for the Audit log
+	served int64
+	// serveErr is the first failed request since the last audit.Served event
+	serveErr error
+	// buildDir is where PreProcess is building the cache before moving it into place
+	buildDir string
 }
 
 func (e *Encoder) PreProcess() (err error) {
@@ -38,6 +93,7 @@ func (e *Encoder) PreProcess() (err error) {
 	}
 
 	// populate block info
+	e.hashes = nil
 	e.coerceBlockSize()
 	e.numBlocks, e.highestBlockSize = e.getBlockInfo(info.Size())
 
@@ -47,32 +103,55 @@ func (e *Encoder) PreProcess() (err error) {
 		return
 	}
 	cacheDir := e.cacheDir()
+	e.sweepBuilds()
 
 	cacheInfo, err := os.Stat(cacheDir)
 	if err == nil {
 		if !cacheInfo.IsDir() {
 			return fmt.Errorf("CacheDir %q is not a directory", cacheDir)
 		}
-		// cache hit
-		fmt.Printf("[encoder] Cache hit for %q\n", e.FileName)
-		return
-	} else if os.IsNotExist(err) {
-		// no cache existing, create one
-		if err = os.MkdirAll(cacheDir, 0750); err != nil {
+		if e.cacheHit() {
+			// cache hit
+			fmt.Printf("[encoder] Cache hit for %q\n", e.FileName)
 			return
 		}
-	} else {
+		// left behind by an older version, or the cache was built without the store
+		fmt.Printf("[encoder] Cache for %q is incomplete, rebuilding\n", e.FileName)
+	} else if !os.IsNotExist(err) {
 		// failed to stat for some reason
 		return
 	}
 
+	// build into a directory of our own and move it into place once complete,
+	// so concurrent builds of the same file never see or remove each other's files
+	if err = os.MkdirAll(path.Dir(cacheDir), 0750); err != nil {
+		return
+	}
+	buildDir, err := e.createBuildDir()
+	if err != nil {
+		return
+	}
+	e.buildDir = buildDir
+	defer func() {
+		e.buildDir = ""
+		if err != nil {
+			// don't leave a partial build behind
+			_ = os.RemoveAll(buildDir)
+		}
+	}()
+	if err = e.checkTempDir(buildDir); err != nil {
+		return
+	}
+
 	// open
 	f, err := os.Open(e.FileName)
 	if err != nil {
 		return
 	}
 	defer func() {
-		err = f.Close()
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
 	}()
 
 	// the first block we read is the highest block which may be smaller than the rest
@@ -93,6 +172,16 @@ func (e *Encoder) PreProcess() (err error) {
 			return
 		}
 
+		if e.Store != nil {
+			var key string
+			if key, err = e.Store.Put(block); err != nil {
+				return
+			}
+			if err = e.writeCacheFile(path.Join(buildDir, blockKeyName(i)), []byte(key), 0440); err != nil {
+				return
+			}
+		}
+
 		// use any existing hash with the block to produce the next one
 		block = append(block, parentHash...)
 
@@ -102,15 +191,21 @@ func (e *Encoder) PreProcess() (err error) {
 			return
 		}
 		parentHash = hash.Sum(nil)
-		err = os.WriteFile(e.hashFile(i), parentHash, 0440)
+		err = e.writeCacheFile(path.Join(buildDir, hashName(i)), parentHash, 0440)
 		if err != nil {
 			return
 		}
+		e.reportProgress(e.numBlocks-i, e.numBlocks)
 
 		// reset the block's data and size for the next read, any following reads will be the full block-size
 		block = make([]byte, e.BlockSize)
 	}
 
+	// the marker is written last, a cache without one is rebuilt by the next PreProcess
+	if err = e.writeCacheFile(path.Join(buildDir, completeName), e.completeMarker(), 0440); err != nil {
+		return
+	}
+	err = e.installBuild(buildDir)
 	return
 }
 
@@ -121,9 +216,17 @@ func (e *Encoder) PreProcess() (err error) {
 // The client may attempt to store the final bytes, but it may not make sense
 // Subsequent requests will return no bytes and an io.EOF error.
 func (e *Encoder) Request(requestNumber int64) ([]byte, error) {
+	block, err := e.request(requestNumber)
+	e.noteServeErr(err)
+	return block, err
+}
+
+func (e *Encoder) request(requestNumber int64) ([]byte, error) {
 	if requestNumber == 0 {
 		// request 0 returns hash 0
-		return os.ReadFile(e.hashFile(requestNumber))
+		hash, err := e.readHash(requestNumber)
+		e.served += int64(len(hash))
+		return hash, err
 	}
 
 	// request 1 returns block 0, request 2 returns block 1
@@ -133,36 +236,23 @@ func (e *Encoder) Request(requestNumber int64) ([]byte, error) {
 		return nil, io.EOF
 	}
 
-	// ensure file is open
-	if e.file == nil {
-		fmt.Printf("[encoder] Opening %q\n", e.FileName)
-		var err error
-		e.file, err = os.Open(e.FileName)
-		// there is no accompanying defer for this open file, it will be closed when the client calls e.Close
+	var block []byte
+	var err error
+	if e.Store != nil {
+		block, err = e.storedBlock(blockIndex)
 		if err != nil {
 			return nil, err
 		}
-	}
-
-	_, err := e.file.Seek(e.BlockSize*blockIndex, os.SEEK_SET)
-	if err != nil {
-		return nil, err
-	}
-
-	readSize := e.BlockSize
-	// last block has potentially smaller block-size
-	if blockIndex == e.numBlocks-1 {
-		readSize = e.highestBlockSize
-	}
-	block := make([]byte, readSize)
-	_, err = e.file.Read(block)
-	if err != nil {
-		return block, err
+	} else {
+		block, err = e.readBlock(blockIndex)
+		if err != nil {
+			return block, err
+		}
 	}
 
 	// if not last block, append parent's hash
 	if blockIndex != e.numBlocks-1 {
-		hash, err := os.ReadFile(e.hashFile(requestNumber))
+		hash, err := e.readHash(requestNumber)
 		if err != nil {
 			return block, err
 		}
@@ -172,14 +262,168 @@ func (e *Encoder) Request(requestNumber int64) ([]byte, error) {
 		block = append(block, make([]byte, 32)...)
 	}
 
+	e.served += int64(len(block))
+	if blockIndex == e.numBlocks-1 {
+		e.auditServed(false)
+	}
+
 	return block, err
 }
 
-// Close is a helper for the client to end the stream early
+// RequestFrame is the streaming form of Request for requestNumber >= 1.
+// It returns a length-prefixed frame that reads the block straight from the file,
+// so large blocks don't need to be held in memory. See decoder.DecodeFrame for the layout.
+// The frame must be closed once the caller is done with it, read to the end or not.
+func (e *Encoder) RequestFrame(requestNumber int64) (io.ReadCloser, error) {
+	f, err := e.requestFrame(requestNumber)
+	e.noteServeErr(err)
+	return f, err
+}
+
+func (e *Encoder) requestFrame(requestNumber int64) (io.ReadCloser, error) {
+	if requestNumber < 1 {
+		return nil, fmt.Errorf("Frames start at request 1, got: %d", requestNumber)
+	}
+	blockIndex := requestNumber - 1
+
+	if blockIndex >= e.numBlocks {
+		return nil, io.EOF
+	}
+
+	readSize := e.BlockSize
+	hash := make([]byte, 32)
+	if blockIndex == e.numBlocks-1 {
+		readSize = e.highestBlockSize
+	} else {
+		var err error
+		hash, err = e.readHash(requestNumber)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	header := make([]byte, decoder.FrameHeaderSize)
+	binary.BigEndian.PutUint64(header, uint64(readSize))
+
+	var payload io.Reader
+	var closer io.Closer
+	if e.Store == nil {
+		if err := e.ensureOpen(); err != nil {
+			return nil, err
+		}
+		payload = io.NewSectionReader(e.file, e.BlockSize*blockIndex, readSize)
+	} else {
+		key, err := os.ReadFile(e.blockKeyFile(blockIndex))
+		if err != nil {
+			return nil, err
+		}
+		f, err := e.Store.Open(string(key))
+		if err != nil {
+			return nil, err
+		}
+		payload, closer = f, f
+	}
+
+	e.served += decoder.FrameHeaderSize + readSize + 32
+	if blockIndex == e.numBlocks-1 {
+		e.auditServed(false)
+	}
+
+	return &frame{
+		Reader: io.MultiReader(
+			bytes.NewReader(header),
+			payload,
+			bytes.NewReader(hash),
+		),
+		closer: closer,
+	}, nil
+}
+
+// frame is returned by RequestFrame, closing the block's file when it was opened from the Store
+type frame struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (f *frame) Close() error {
+	if f.closer == nil {
+		// the source file stays open until Encoder.Close
+		return nil
+	}
+	return f.closer.Close()
+}
+
+// InitialHash returns the hash of the first block, the same as Request(0).
+func (e *Encoder) InitialHash() ([]byte, error) {
+	return e.HashAt(0)
+}
+
+// HashAt returns the hash of blockIndex, which anchors verification of the stream from that block on.
+func (e *Encoder) HashAt(blockIndex int64) ([]byte, error) {
+	if blockIndex < 0 || blockIndex >= e.numBlocks {
+		return nil, fmt.Errorf("Invalid block index %d for %d blocks", blockIndex, e.numBlocks)
+	}
+	return e.readHash(blockIndex)
+}
+
+// Warm loads every block hash into memory, so Requests no longer read hashes from the cache on disk.
+func (e *Encoder) Warm() error {
+	return e.WarmRange(0, e.numBlocks)
+}
+
+// WarmRange loads the hashes needed to serve blocks start through end-1 into memory:
+// the hash anchoring block start, and the hash appended to each block in the range.
+// Hashes outside of the range are still read from disk. Ranges may be warmed repeatedly, adding to what's loaded.
+func (e *Encoder) WarmRange(start, end int64) error {
+	if start < 0 || end > e.numBlocks || start > end {
+		return fmt.Errorf("Invalid block range [%d, %d) for %d blocks", start, end, e.numBlocks)
+	}
+	// the last block is followed by the 0-hash, which isn't stored
+	if end < e.numBlocks {
+		end++
+	}
+
+	if e.hashes == nil {
+		e.hashes = map[int64][]byte{}
+	}
+	for i := start; i < end; i++ {
+		if _, ok := e.hashes[i]; ok {
+			continue
+		}
+		hash, err := os.ReadFile(e.hashFile(i))
+		if err != nil {
+			return err
+		}
+		e.hashes[i] = hash
+	}
+	return nil
+}
+
+// MemoryUsage returns the bytes of hashes held in memory by Warm and WarmRange.
+func (e *Encoder) MemoryUsage() int64 {
+	return int64(len(e.hashes)) * 32
+}
+
+// Close is a helper for the client to end the stream early.
+// A stream that ended before its final block is still recorded in the Audit log, as partially served.
 func (e *Encoder) Close() error {
+	if e.served > 0 || e.serveErr != nil {
+		e.auditServed(true)
+	}
 	return e.file.Close()
 }
 
+// ensureOpen opens FileName if it isn't open yet
+func (e *Encoder) ensureOpen() (err error) {
+	if e.file != nil {
+		return
+	}
+	fmt.Printf("[encoder] Opening %q\n", e.FileName)
+	// there is no accompanying defer for this open file, it will be closed when the client calls e.Close
+	e.file, err = os.Open(e.FileName)
+	return
+}
+
 func (e *Encoder) initCacheKey() (err error) {
 	fpath, err := filepath.Abs(e.FileName)
 	if err != nil {
@@ -195,11 +439,269 @@ func (e *Encoder) initCacheKey() (err error) {
 }
 
 func (e *Encoder) cacheDir() string {
-	return path.Join("cache", e.cacheKey)
+	root := e.CacheRoot
+	if root == "" {
+		root = defaultCacheRoot
+	}
+	return path.Join(root, e.cacheKey)
+}
+
+// installBuild moves a completed build into place as the cache.
+// If a concurrent build was installed first, ours is discarded in favour of it.
+func (e *Encoder) installBuild(buildDir string) (err error) {
+	cacheDir := e.cacheDir()
+	if err = rename(buildDir, cacheDir); err == nil {
+		return
+	}
+	if _, statErr := os.Stat(cacheDir); statErr != nil {
+		// the rename failed for some other reason
+		return
+	}
+	if e.cacheHit() {
+		return os.RemoveAll(buildDir)
+	}
+
+	// move the stale cache aside rather than removing it where another build could be reading it
+	stale := buildDir + ".stale"
+	if err = rename(cacheDir, stale); err != nil {
+		return
+	}
+	if err = rename(buildDir, cacheDir); err != nil && e.cacheHit() {
+		// a concurrent build was installed in between
+		err = os.RemoveAll(buildDir)
+	}
+	// the stale cache was incomplete, it's removed whether or not ours could replace it
+	if removeErr := os.RemoveAll(stale); err == nil {
+		err = removeErr
+	}
+	return
+}
+
+// sweepBuilds removes builds of this cache abandoned by crashed runs.
+// In-progress builds keep touching their directory with each block, so only builds idle for staleBuildAge are removed.
+func (e *Encoder) sweepBuilds() {
+	root := path.Dir(e.cacheDir())
+	stale, _ := filepath.Glob(path.Join(root, e.cacheKey+"-*.build.stale"))
+	builds, _ := filepath.Glob(path.Join(root, e.cacheKey+"-*.build"))
+	for _, build := range builds {
+		if info, err := os.Stat(build); err == nil && time.Since(info.ModTime()) > staleBuildAge {
+			stale = append(stale, build)
+		}
+	}
+	for _, dir := range stale {
+		fmt.Printf("[encoder] Removing abandoned build %q\n", dir)
+		_ = os.RemoveAll(dir)
+	}
+}
+
+// createBuildDir makes a new directory next to the cache to build it in
+func (e *Encoder) createBuildDir() (string, error) {
+	root := path.Dir(e.cacheDir())
+	if !e.DeterministicTempNames {
+		return os.MkdirTemp(root, e.cacheKey+"-*.build")
+	}
+	for {
+		name := path.Join(root, e.tempName(".build"))
+		err := os.Mkdir(name, 0750)
+		if os.IsExist(err) {
+			continue
+		}
+		return name, err
+	}
+}
+
+const completeName = "complete"
+
+func (e *Encoder) completeFile() string {
+	return path.Join(e.cacheDir(), completeName)
+}
+
+// completeMarker records how the cache was built
+func (e *Encoder) completeMarker() []byte {
+	return []byte(fmt.Sprintf("store=%t\n", e.Store != nil))
+}
+
+// cacheHit reports whether the cache is complete, and was built with a Store if one is in use now
+func (e *Encoder) cacheHit() bool {
+	marker, err := os.ReadFile(e.completeFile())
+	if err != nil {
+		return false
+	}
+	return e.Store == nil || bytes.Equal(marker, []byte("store=true\n"))
+}
+
+func blockKeyName(blockIndex int64) string {
+	return fmt.Sprintf("%d.block", blockIndex)
+}
+
+func (e *Encoder) blockKeyFile(blockIndex int64) string {
+	return path.Join(e.cacheDir(), blockKeyName(blockIndex))
+}
+
+func (e *Encoder) readBlock(blockIndex int64) ([]byte, error) {
+	if err := e.ensureOpen(); err != nil {
+		return nil, err
+	}
+
+	_, err := e.file.Seek(e.BlockSize*blockIndex, os.SEEK_SET)
+	if err != nil {
+		return nil, err
+	}
+
+	readSize := e.BlockSize
+	// last block has potentially smaller block-size
+	if blockIndex == e.numBlocks-1 {
+		readSize = e.highestBlockSize
+	}
+	block := make([]byte, readSize)
+	_, err = e.file.Read(block)
+	return block, err
+}
+
+func (e *Encoder) storedBlock(blockIndex int64) ([]byte, error) {
+	key, err := os.ReadFile(e.blockKeyFile(blockIndex))
+	if err != nil {
+		return nil, err
+	}
+	return e.Store.Get(string(key))
+}
+
+func (e *Encoder) tempDir() string {
+	if e.TempDir != "" {
+		return e.TempDir
+	}
+	if e.buildDir != "" {
+		return e.buildDir
+	}
+	return e.cacheDir()
+}
+
+// tempName is a deterministic temp name, unique to this process
+func (e *Encoder) tempName(suffix string) string {
+	return fmt.Sprintf("%s-%d-%d%s", e.cacheKey, os.Getpid(), atomic.AddInt64(&tempSeq, 1), suffix)
+}
+
+// createTemp opens a new temp file in the TempDir with the given suffix.
+// Names are random unless DeterministicTempNames is set.
+func (e *Encoder) createTemp(suffix string) (*os.File, error) {
+	dir := e.tempDir()
+	if !e.DeterministicTempNames {
+		return os.CreateTemp(dir, e.cacheKey+"-*"+suffix)
+	}
+	for {
+		name := path.Join(dir, e.tempName(suffix))
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			// left behind by an earlier process that shared our pid, try the next seq
+			continue
+		}
+		return f, err
+	}
+}
+
+// checkTempDir ensures temp files can be renamed into dir, which fails when TempDir is on another filesystem
+func (e *Encoder) checkTempDir(dir string) (err error) {
+	if e.TempDir == "" {
+		return
+	}
+	f, err := e.createTemp(".probe")
+	if err != nil {
+		return
+	}
+	f.Close()
+	probe := path.Join(dir, path.Base(f.Name()))
+	if err = rename(f.Name(), probe); err != nil {
+		_ = os.Remove(f.Name())
+		return fmt.Errorf("TempDir %q must be on the same filesystem as the cache in %q: %w", e.TempDir, dir, err)
+	}
+	return os.Remove(probe)
+}
+
+// writeCacheFile writes data to a temp file and moves it to name, so readers never observe a partial file
+func (e *Encoder) writeCacheFile(name string, data []byte, perm os.FileMode) (err error) {
+	f, err := e.createTemp(path.Ext(name) + ".tmp")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+	if err = os.Chmod(f.Name(), perm); err != nil {
+		return
+	}
+	return rename(f.Name(), name)
+}
+
+func hashName(blockIndex int64) string {
+	return fmt.Sprintf("%d.sha256", blockIndex)
 }
 
 func (e *Encoder) hashFile(blockIndex int64) string {
-	return path.Join(e.cacheDir(), fmt.Sprintf("%d.sha256", blockIndex))
+	return path.Join(e.cacheDir(), hashName(blockIndex))
+}
+
+// readHash returns the hash of blockIndex, from memory when it has been warmed
+func (e *Encoder) readHash(blockIndex int64) ([]byte, error) {
+	if hash, ok := e.hashes[blockIndex]; ok {
+		return append([]byte{}, hash...), nil
+	}
+	return os.ReadFile(e.hashFile(blockIndex))
+}
+
+// noteServeErr keeps the first failed request of the stream for the Audit log
+func (e *Encoder) noteServeErr(err error) {
+	if err != nil && err != io.EOF && e.serveErr == nil {
+		e.serveErr = err
+	}
+}
+
+// auditServed records the stream served so far, and starts counting a new one
+func (e *Encoder) auditServed(partial bool) {
+	defer func() {
+		e.served = 0
+		e.serveErr = nil
+	}()
+	if e.Audit == nil {
+		return
+	}
+	event := audit.Event{
+		Event:   audit.Served,
+		Stream:  e.StreamID,
+		Bytes:   e.served,
+		Partial: partial,
+	}
+	if event.Stream == "" {
+		event.Stream = e.FileName
+	}
+	if e.serveErr != nil {
+		event.Error = e.serveErr.Error()
+	}
+	if hash, err := e.readHash(0); err == nil {
+		event.InitialHash = hex.EncodeToString(hash)
+	}
+	e.Audit.Record(event)
+}
+
+func (e *Encoder) reportProgress(done, total int64) {
+	if e.Progress != nil {
+		e.Progress(done, total)
+	}
+	if e.ProgressCh != nil {
+		select {
+		case e.ProgressCh <- ProgressUpdate{Done: done, Total: total}:
+		default:
+		}
+	}
 }
 
 func (e *Encoder) coerceBlockSize() {
@@ -215,3 +717,44 @@ func (e *Encoder) getBlockInfo(fileSize int64) (numBlocks, highestBlockSize int6
 	fmt.Printf("[encoder] numBlocks: %d, highestBlockSize: %d\n", numBlocks, highestBlockSize)
 	return
 }
+
+// HashFiles reads a stream's hashes out of its cache one file at a time, in block order.
+// It satisfies decoder.HashList.
+type HashFiles struct {
+	e    *Encoder
+	next int64
+}
+
+// Hashes returns the hash of every block, read lazily from the cache as the list is iterated.
+func (e *Encoder) Hashes() *HashFiles {
+	return &HashFiles{e: e}
+}
+
+// Next returns the hash of the next block, or io.EOF after the last block
+func (h *HashFiles) Next() ([]byte, error) {
+	if h.next >= h.e.numBlocks {
+		return nil, io.EOF
+	}
+	hash, err := h.e.readHash(h.next)
+	if err != nil {
+		return nil, err
+	}
+	h.next++
+	return hash, nil
+}
+
+// PackHashes writes the hash of every block back to back to w, for reading with decoder.NewHashReader.
+func (e *Encoder) PackHashes(w io.Writer) error {
+	hashes := e.Hashes()
+	for {
+		hash, err := hashes.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if _, err = w.Write(hash); err != nil {
+			return err
+		}
+	}
+}
@@ -1,11 +1,23 @@
 package encoder
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
+
+	"stealthybox.dev/go-hash-player/decoder"
 )
 
 func TestEncoder(t *testing.T) {
@@ -130,3 +142,672 @@ func TestEncoder(t *testing.T) {
 		})
 	}
 }
+
+func TestDeterministicTempNames(t *testing.T) {
+	e := Encoder{
+		FileName:               "../testdata/test_0",
+		BlockSize:              1024,
+		CacheRoot:              t.TempDir(),
+		TempDir:                t.TempDir(),
+		DeterministicTempNames: true,
+	}
+	if err := e.PreProcess(); err != nil {
+		t.Fatalf("failed preprocessing: %v", err)
+	}
+
+	// the build moved all of its temp files into the cache
+	leftover, err := os.ReadDir(e.TempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leftover) != 0 {
+		t.Fatalf("expected no leftover temp files, got: %v", leftover)
+	}
+
+	pattern := regexp.MustCompile(fmt.Sprintf(`^%s-%d-(\d+)\.debug$`, e.cacheKey, os.Getpid()))
+	seen := map[string]bool{}
+	for i := 0; i < 5; i++ {
+		f, err := e.createTemp(".debug")
+		if err != nil {
+			t.Fatalf("failed creating temp file: %v", err)
+		}
+		f.Close()
+
+		if filepath.Dir(f.Name()) != e.TempDir {
+			t.Fatalf("expected temp file in %q, got: %q", e.TempDir, f.Name())
+		}
+		name := filepath.Base(f.Name())
+		if !pattern.MatchString(name) {
+			t.Fatalf("temp file name %q does not match %v", name, pattern)
+		}
+		if seen[name] {
+			t.Fatalf("temp file name %q was reused", name)
+		}
+		seen[name] = true
+	}
+
+	// a stale file occupying the next name is skipped rather than clobbered
+	next := fmt.Sprintf("%s-%d-%d.debug", e.cacheKey, os.Getpid(), atomic.LoadInt64(&tempSeq)+1)
+	stale := filepath.Join(e.TempDir, next)
+	if err := os.WriteFile(stale, []byte("stale"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := e.createTemp(".debug")
+	if err != nil {
+		t.Fatalf("failed creating temp file: %v", err)
+	}
+	f.Close()
+	if f.Name() == stale {
+		t.Fatalf("temp file collided with existing file %q", stale)
+	}
+	if b, _ := os.ReadFile(stale); string(b) != "stale" {
+		t.Fatalf("existing file was modified, got: %q", b)
+	}
+}
+
+func TestRandomTempNames(t *testing.T) {
+	e := Encoder{
+		FileName:  "../testdata/test_0",
+		CacheRoot: t.TempDir(),
+		TempDir:   t.TempDir(),
+	}
+	if err := e.initCacheKey(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := e.createTemp(".debug")
+	if err != nil {
+		t.Fatalf("failed creating temp file: %v", err)
+	}
+	f.Close()
+	if regexp.MustCompile(fmt.Sprintf(`-%d-\d+\.debug$`, os.Getpid())).MatchString(f.Name()) {
+		t.Fatalf("expected a random temp file name by default, got: %q", f.Name())
+	}
+}
+
+func TestProgressChannel(t *testing.T) {
+	progress := make(chan ProgressUpdate, 4)
+	var last ProgressUpdate
+	e := Encoder{
+		FileName:  "../testdata/test_01.input.mp4",
+		BlockSize: 4096,
+		CacheRoot: t.TempDir(),
+		Progress: func(done, total int64) {
+			last = ProgressUpdate{Done: done, Total: total}
+		},
+		ProgressCh: progress,
+	}
+
+	// a slow consumer that only sees some of the updates
+	received := make(chan []ProgressUpdate)
+	stop := make(chan struct{})
+	go func() {
+		var updates []ProgressUpdate
+		for {
+			select {
+			case u := <-progress:
+				updates = append(updates, u)
+				time.Sleep(time.Millisecond)
+			case <-stop:
+				received <- updates
+				return
+			}
+		}
+	}()
+
+	done := make(chan error)
+	go func() {
+		done <- e.PreProcess()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("failed preprocessing: %v", err)
+		}
+	case <-time.After(time.Minute):
+		t.Fatalf("PreProcess blocked on a slow progress consumer")
+	}
+	close(stop)
+	updates := <-received
+
+	if last.Done != e.numBlocks || last.Total != e.numBlocks {
+		t.Fatalf("expected Progress callback to reach %d/%d, got: %+v", e.numBlocks, e.numBlocks, last)
+	}
+	if len(updates) == 0 {
+		t.Fatalf("expected some progress updates on the channel")
+	}
+	for i, u := range updates {
+		if u.Total != e.numBlocks {
+			t.Fatalf("update %d expected total %d, got: %d", i, e.numBlocks, u.Total)
+		}
+		if i > 0 && u.Done <= updates[i-1].Done {
+			t.Fatalf("update %d is not increasing: %+v after %+v", i, u, updates[i-1])
+		}
+	}
+}
+
+func TestRequestFrame(t *testing.T) {
+	e := Encoder{
+		FileName:  "../testdata/test_1",
+		BlockSize: 1024,
+		CacheRoot: t.TempDir(),
+	}
+	if err := e.PreProcess(); err != nil {
+		t.Fatalf("failed preprocessing: %v", err)
+	}
+	defer e.Close()
+
+	for i := int64(1); ; i++ {
+		hashedBlock, reqErr := e.Request(i)
+		frameReader, frameErr := e.RequestFrame(i)
+		if reqErr == io.EOF {
+			if frameErr != io.EOF {
+				t.Fatalf("request %d expected %v, got: %v", i, io.EOF, frameErr)
+			}
+			break
+		}
+		if reqErr != nil || frameErr != nil {
+			t.Fatalf("request %d failed: %v, %v", i, reqErr, frameErr)
+		}
+
+		frame, err := io.ReadAll(frameReader)
+		frameReader.Close()
+		if err != nil {
+			t.Fatalf("request %d failed reading frame: %v", i, err)
+		}
+		header := make([]byte, decoder.FrameHeaderSize)
+		binary.BigEndian.PutUint64(header, uint64(len(hashedBlock)-32))
+		if !reflect.DeepEqual(frame, append(header, hashedBlock...)) {
+			t.Fatalf("request %d frame does not match hashed block", i)
+		}
+	}
+
+	if _, err := e.RequestFrame(0); err == nil {
+		t.Fatalf("expected error for frame 0")
+	}
+}
+
+func TestBlockStoreDedup(t *testing.T) {
+	const blockSize = 1024
+	block := func(b byte) []byte {
+		return bytes.Repeat([]byte{b}, blockSize)
+	}
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"a": bytes.Join([][]byte{block(1), block(2), block(3), block(4), block(1)[:100]}, nil),
+		"b": bytes.Join([][]byte{block(1), block(2), block(5), block(4), block(3)}, nil),
+	}
+
+	store := &BlockStore{Dir: filepath.Join(dir, "store")}
+	cacheRoot := filepath.Join(dir, "cache")
+	for name, data := range files {
+		fileName := filepath.Join(dir, name)
+		if err := os.WriteFile(fileName, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		e := Encoder{
+			FileName:  fileName,
+			BlockSize: blockSize,
+			CacheRoot: cacheRoot,
+			Store:     store,
+		}
+		if err := e.PreProcess(); err != nil {
+			t.Fatalf("%s: failed preprocessing: %v", name, err)
+		}
+	}
+
+	// 10 blocks across both streams, 6 distinct payloads
+	stored, err := os.ReadDir(store.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 6 {
+		t.Fatalf("expected 6 deduplicated blocks in the store, got: %d", len(stored))
+	}
+
+	for name, data := range files {
+		fileName := filepath.Join(dir, name)
+		e := Encoder{
+			FileName:  fileName,
+			BlockSize: blockSize,
+			CacheRoot: cacheRoot,
+			Store:     store,
+		}
+		if err := e.PreProcess(); err != nil {
+			t.Fatalf("%s: failed preprocessing: %v", name, err)
+		}
+		// blocks are served out of the store
+		if err := os.Remove(fileName); err != nil {
+			t.Fatal(err)
+		}
+
+		var got []byte
+		hash, err := e.Request(0)
+		if err != nil {
+			t.Fatalf("%s: failed on initial hash: %v", name, err)
+		}
+		for i := int64(1); ; i++ {
+			hashedBlock, err := e.Request(i)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s: failed on request %d: %v", name, i, err)
+			}
+			sum := sha256.Sum256(hashedBlock)
+			if !bytes.Equal(sum[:], hash) {
+				t.Fatalf("%s: request %d failed verification", name, i)
+			}
+			hash = hashedBlock[len(hashedBlock)-32:]
+			got = append(got, hashedBlock[:len(hashedBlock)-32]...)
+
+			frameReader, err := e.RequestFrame(i)
+			if err != nil {
+				t.Fatalf("%s: failed on frame %d: %v", name, i, err)
+			}
+			frame, err := io.ReadAll(frameReader)
+			frameReader.Close()
+			if err != nil {
+				t.Fatalf("%s: failed reading frame %d: %v", name, i, err)
+			}
+			if !bytes.Equal(frame[8:], hashedBlock) {
+				t.Fatalf("%s: frame %d does not match request", name, i)
+			}
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("%s: served data does not match original", name)
+		}
+
+		// an abandoned frame still releases its block file when closed
+		frameReader, err := e.RequestFrame(2)
+		if err != nil {
+			t.Fatalf("%s: failed on frame 2: %v", name, err)
+		}
+		if _, err := io.ReadFull(frameReader, make([]byte, 16)); err != nil {
+			t.Fatalf("%s: failed reading frame 2: %v", name, err)
+		}
+		if err := frameReader.Close(); err != nil {
+			t.Fatalf("%s: failed closing frame 2: %v", name, err)
+		}
+		if _, err := io.ReadAll(frameReader); !errors.Is(err, os.ErrClosed) {
+			t.Fatalf("%s: expected the block file to be closed, got: %v", name, err)
+		}
+	}
+
+	// a cache built without the store is rebuilt to fill the store in
+	fileName := filepath.Join(dir, "c")
+	if err := os.WriteFile(fileName, bytes.Join([][]byte{block(6), block(7)}, nil), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e := Encoder{
+		FileName:  fileName,
+		BlockSize: blockSize,
+		CacheRoot: cacheRoot,
+	}
+	if err := e.PreProcess(); err != nil {
+		t.Fatalf("c: failed preprocessing: %v", err)
+	}
+	e.Store = store
+	if e.cacheHit() {
+		t.Fatalf("c: expected a cache built without the store to miss")
+	}
+	if err := e.PreProcess(); err != nil {
+		t.Fatalf("c: failed preprocessing with the store: %v", err)
+	}
+	if !e.cacheHit() {
+		t.Fatalf("c: expected the rebuilt cache to hit")
+	}
+	if stored, _ := os.ReadDir(store.Dir); len(stored) != 8 {
+		t.Fatalf("expected 8 blocks in the store after rebuilding, got: %d", len(stored))
+	}
+	// without the store, a cache built with one still hits
+	e.Store = nil
+	if !e.cacheHit() {
+		t.Fatalf("c: expected a cache built with the store to hit without it")
+	}
+}
+
+func TestWarmRange(t *testing.T) {
+	e := Encoder{
+		FileName:  "../testdata/test_1",
+		BlockSize: 1024,
+		CacheRoot: t.TempDir(),
+	}
+	if err := e.PreProcess(); err != nil {
+		t.Fatalf("failed preprocessing: %v", err)
+	}
+	defer e.Close()
+
+	// record every request before warming, to compare against
+	want := map[int64][]byte{}
+	for i := int64(0); i <= e.numBlocks; i++ {
+		b, err := e.Request(i)
+		if err != nil {
+			t.Fatalf("failed on request %d: %v", i, err)
+		}
+		want[i] = b
+	}
+
+	if err := e.WarmRange(3, 6); err != nil {
+		t.Fatalf("failed warming range: %v", err)
+	}
+	if len(e.hashes) != 4 {
+		t.Fatalf("expected 4 hashes in memory for blocks [3, 6), got: %d", len(e.hashes))
+	}
+
+	// with the hashes gone from disk, only the warmed blocks can still be served
+	for i := int64(0); i < e.numBlocks; i++ {
+		if err := os.Remove(e.hashFile(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for request := int64(0); request < e.numBlocks; request++ {
+		got, err := e.Request(request)
+		// request n+1 serves block n, request 3 is the anchor of block 3 appended to block 2
+		warmed := request >= 3 && request <= 6
+		if warmed && err != nil {
+			t.Fatalf("request %d expected to be served from memory, got: %v", request, err)
+		}
+		if warmed && !reflect.DeepEqual(got, want[request]) {
+			t.Fatalf("request %d served from memory does not match", request)
+		}
+		if !warmed && !os.IsNotExist(err) {
+			t.Fatalf("request %d expected to read from disk and fail, got: %v", request, err)
+		}
+	}
+
+	// the last block's trailing 0-hash doesn't need warming
+	if err := e.WarmRange(e.numBlocks-1, e.numBlocks); !os.IsNotExist(err) {
+		t.Fatalf("expected warming the last block to read only its own hash, got: %v", err)
+	}
+
+	for _, r := range [][2]int64{{-1, 2}, {4, 3}, {0, e.numBlocks + 1}} {
+		if err := e.WarmRange(r[0], r[1]); err == nil {
+			t.Fatalf("expected error warming invalid range %v", r)
+		}
+	}
+}
+
+func TestPreProcessCleanup(t *testing.T) {
+	newEncoder := func(cacheRoot string) *Encoder {
+		return &Encoder{
+			FileName:  "../testdata/test_1",
+			BlockSize: 1024,
+			CacheRoot: cacheRoot,
+		}
+	}
+
+	// fail partway through the build
+	injected := errors.New("injected failure")
+	renames := 0
+	rename = func(from, to string) error {
+		if renames++; renames == 5 {
+			return injected
+		}
+		return os.Rename(from, to)
+	}
+	defer func() { rename = os.Rename }()
+
+	cacheRoot := t.TempDir()
+	e := newEncoder(cacheRoot)
+	if err := e.PreProcess(); !errors.Is(err, injected) {
+		t.Fatalf("expected %v, got: %v", injected, err)
+	}
+	if leftover, _ := os.ReadDir(cacheRoot); len(leftover) != 0 {
+		t.Fatalf("expected the partial build to be removed, got: %v", leftover)
+	}
+	rename = os.Rename
+
+	// a partial cache left behind without the completion marker is rebuilt, not treated as a hit
+	if err := os.MkdirAll(e.cacheDir(), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(e.hashFile(0), make([]byte, 32), 0440); err != nil {
+		t.Fatal(err)
+	}
+
+	e = newEncoder(cacheRoot)
+	if err := e.PreProcess(); err != nil {
+		t.Fatalf("failed rebuilding: %v", err)
+	}
+	if !e.cacheHit() {
+		t.Fatalf("expected completed cache to be marked")
+	}
+	if leftover, _ := os.ReadDir(cacheRoot); len(leftover) != 1 {
+		t.Fatalf("expected only the rebuilt cache to remain, got: %v", leftover)
+	}
+	hash, err := e.Request(0)
+	if err != nil {
+		t.Fatalf("failed on initial hash: %v", err)
+	}
+	hashedBlock, err := e.Request(1)
+	if err != nil {
+		t.Fatalf("failed on first block: %v", err)
+	}
+	defer e.Close()
+	sum := sha256.Sum256(hashedBlock)
+	if !reflect.DeepEqual(hash, sum[:]) {
+		t.Fatalf("rebuilt cache failed verification, expected: %v, got: %v", hash, sum)
+	}
+}
+
+func TestPackHashes(t *testing.T) {
+	e := Encoder{
+		FileName:  "../testdata/test_1",
+		BlockSize: 1024,
+		CacheRoot: t.TempDir(),
+	}
+	if err := e.PreProcess(); err != nil {
+		t.Fatalf("failed preprocessing: %v", err)
+	}
+
+	var packed bytes.Buffer
+	if err := e.PackHashes(&packed); err != nil {
+		t.Fatalf("failed packing hashes: %v", err)
+	}
+	if packed.Len() != int(e.numBlocks)*32 {
+		t.Fatalf("expected %d packed bytes, got: %d", e.numBlocks*32, packed.Len())
+	}
+
+	hashes := e.Hashes()
+	for i := int64(0); i < e.numBlocks; i++ {
+		hash, err := hashes.Next()
+		if err != nil {
+			t.Fatalf("failed on hash %d: %v", i, err)
+		}
+		onDisk, err := os.ReadFile(e.hashFile(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(hash, onDisk) || !reflect.DeepEqual(packed.Next(32), onDisk) {
+			t.Fatalf("hash %d does not match its cache file", i)
+		}
+	}
+	if _, err := hashes.Next(); err != io.EOF {
+		t.Fatalf("expected %v after the last hash, got: %v", io.EOF, err)
+	}
+}
+
+func TestTempDirOtherFilesystem(t *testing.T) {
+	// renames out of TempDir fail the way they do across filesystems
+	rename = func(from, to string) error {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: syscall.EXDEV}
+	}
+	defer func() { rename = os.Rename }()
+
+	e := Encoder{
+		FileName:  "../testdata/test_0",
+		BlockSize: 1024,
+		CacheRoot: t.TempDir(),
+		TempDir:   t.TempDir(),
+	}
+	err := e.PreProcess()
+	if !errors.Is(err, syscall.EXDEV) || !strings.Contains(err.Error(), "same filesystem") {
+		t.Fatalf("expected PreProcess to reject TempDir on another filesystem, got: %v", err)
+	}
+	if _, err := os.Stat(e.hashFile(0)); !os.IsNotExist(err) {
+		t.Fatalf("expected no hashes to be written, got: %v", err)
+	}
+	if leftover, _ := os.ReadDir(e.TempDir); len(leftover) != 0 {
+		t.Fatalf("expected the probe to be cleaned up, got: %v", leftover)
+	}
+}
+
+func TestPreProcessConcurrent(t *testing.T) {
+	cacheRoot := t.TempDir()
+	const runs = 8
+
+	// each run races to build and install the same cache
+	errs := make(chan error, runs)
+	encoders := make([]*Encoder, runs)
+	for i := range encoders {
+		encoders[i] = &Encoder{
+			FileName:  "../testdata/test_01.input.mp4",
+			BlockSize: 4096,
+			CacheRoot: cacheRoot,
+		}
+		go func(e *Encoder) {
+			errs <- e.PreProcess()
+		}(encoders[i])
+	}
+	for i := 0; i < runs; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("concurrent PreProcess failed: %v", err)
+		}
+	}
+
+	if leftover, _ := os.ReadDir(cacheRoot); len(leftover) != 1 {
+		t.Fatalf("expected a single installed cache, got: %v", leftover)
+	}
+
+	for i, e := range encoders {
+		hash, err := e.Request(0)
+		if err != nil {
+			t.Fatalf("encoder %d failed on initial hash: %v", i, err)
+		}
+		for request := int64(1); request <= e.numBlocks; request++ {
+			hashedBlock, err := e.Request(request)
+			if err != nil {
+				t.Fatalf("encoder %d failed on request %d: %v", i, request, err)
+			}
+			sum := sha256.Sum256(hashedBlock)
+			if !reflect.DeepEqual(hash, sum[:]) {
+				t.Fatalf("encoder %d request %d failed verification", i, request)
+			}
+			hash = hashedBlock[len(hashedBlock)-32:]
+		}
+		e.Close()
+	}
+}
+
+func TestAbandonedBuilds(t *testing.T) {
+	cacheRoot := t.TempDir()
+	e := &Encoder{
+		FileName:  "../testdata/test_1",
+		BlockSize: 1024,
+		CacheRoot: cacheRoot,
+	}
+	if err := e.initCacheKey(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a stale cache that can't be replaced is still removed, along with the build
+	if err := os.MkdirAll(e.cacheDir(), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(e.hashFile(0), make([]byte, 32), 0440); err != nil {
+		t.Fatal(err)
+	}
+	injected := errors.New("injected failure")
+	rename = func(from, to string) error {
+		if to == e.cacheDir() {
+			if _, err := os.Stat(to); os.IsNotExist(err) {
+				return injected
+			}
+		}
+		return os.Rename(from, to)
+	}
+	defer func() { rename = os.Rename }()
+	if err := e.PreProcess(); !errors.Is(err, injected) {
+		t.Fatalf("expected %v, got: %v", injected, err)
+	}
+	if leftover, _ := os.ReadDir(cacheRoot); len(leftover) != 0 {
+		t.Fatalf("expected the stale cache and build to be removed, got: %v", leftover)
+	}
+	rename = os.Rename
+
+	// builds left behind by crashed runs are swept once they've been idle long enough
+	abandoned := filepath.Join(cacheRoot, e.cacheKey+"-1.build")
+	inProgress := filepath.Join(cacheRoot, e.cacheKey+"-2.build")
+	stale := filepath.Join(cacheRoot, e.cacheKey+"-3.build.stale")
+	other := filepath.Join(cacheRoot, "otherkey-4.build")
+	for _, dir := range []string{abandoned, inProgress, stale, other} {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * staleBuildAge)
+	for _, dir := range []string{abandoned, other} {
+		if err := os.Chtimes(dir, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := e.PreProcess(); err != nil {
+		t.Fatalf("failed preprocessing: %v", err)
+	}
+	for _, dir := range []string{abandoned, stale} {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Fatalf("expected %q to be swept, got: %v", dir, err)
+		}
+	}
+	for _, dir := range []string{inProgress, other} {
+		if _, err := os.Stat(dir); err != nil {
+			t.Fatalf("expected %q to be kept, got: %v", dir, err)
+		}
+	}
+	if !e.cacheHit() {
+		t.Fatalf("expected the cache to be built")
+	}
+}
+
+func TestAuditHashFiles(t *testing.T) {
+	e := Encoder{
+		FileName:  "../testdata/test_01.input.mp4",
+		BlockSize: 4096,
+		CacheRoot: t.TempDir(),
+	}
+	if err := e.PreProcess(); err != nil {
+		t.Fatalf("failed preprocessing: %v", err)
+	}
+	defer e.Close()
+
+	requests := func() func() ([]byte, error) {
+		var i int64
+		return func() ([]byte, error) {
+			i++
+			return e.Request(i)
+		}
+	}
+
+	blocks, err := decoder.Audit(e.Hashes(), requests())
+	if err != nil {
+		t.Fatalf("failed auditing: %v", err)
+	}
+	if blocks != e.numBlocks {
+		t.Fatalf("expected %d audited blocks, got: %d", e.numBlocks, blocks)
+	}
+
+	// a truncated hash file fails the audit instead of crashing it
+	hash, err := os.ReadFile(e.hashFile(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(e.hashFile(7), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(e.hashFile(7), hash[:16], 0640); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decoder.Audit(e.Hashes(), requests()); err == nil || !strings.Contains(err.Error(), "block 7:") {
+		t.Fatalf("expected the truncated hash of block 7 to fail the audit, got: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package encoder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+)
+
+// BlockStore is a content-addressed store of block payloads, named by the sha256 of their contents.
+// Encoders sharing a BlockStore store identical blocks once, no matter which file or offset they came from.
+// This is independent of the hash chain: the chain hashes still cover each block's position in its own stream.
+//
+// The deduplication costs an indirection at serve time, every Request reads the block's key from
+// the cache before opening the block in the store, instead of a single read from the source file.
+type BlockStore struct {
+	Dir string
+}
+
+// Put stores block if it isn't already present and returns its key.
+func (s *BlockStore) Put(block []byte) (key string, err error) {
+	sum := sha256.Sum256(block)
+	key = hex.EncodeToString(sum[:])
+
+	if _, err = os.Stat(s.blockPath(key)); err == nil {
+		return
+	} else if !os.IsNotExist(err) {
+		return
+	}
+
+	if err = os.MkdirAll(s.Dir, 0750); err != nil {
+		return
+	}
+	f, err := os.CreateTemp(s.Dir, key+"-*.tmp")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	_, err = f.Write(block)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+	if err = os.Chmod(f.Name(), 0440); err != nil {
+		return
+	}
+	// a concurrent Put of the same block renames identical contents over ours, which is harmless
+	err = os.Rename(f.Name(), s.blockPath(key))
+	return
+}
+
+// Get returns the block stored under key.
+func (s *BlockStore) Get(key string) ([]byte, error) {
+	if err := validKey(key); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(s.blockPath(key))
+}
+
+// Open returns the block stored under key for streaming reads.
+func (s *BlockStore) Open(key string) (*os.File, error) {
+	if err := validKey(key); err != nil {
+		return nil, err
+	}
+	return os.Open(s.blockPath(key))
+}
+
+func (s *BlockStore) blockPath(key string) string {
+	return path.Join(s.Dir, key)
+}
+
+func validKey(key string) error {
+	if b, err := hex.DecodeString(key); err != nil || len(b) != sha256.Size {
+		return fmt.Errorf("Invalid block key %q", key)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package player
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const defaultResponseBuffer = 4 << 10
+
+// PlaintextHandler serves the decoded contents of a stream over HTTP, verifying every block server-side
+// for clients that can't verify the hash chain themselves.
+//
+// A block is only written once it has been verified, and the start of the response is held back until
+// BufferSize bytes have been verified. A failure within that first stretch gets the client a 500.
+// Past it the status has already been sent, so a failure resets the connection instead of ending the
+// response cleanly, leaving the client with a truncated body it can't mistake for the whole stream.
+type PlaintextHandler struct {
+	// Open returns a new Player for the stream a request is asking for.
+	// Sources that are an io.Closer are closed once the request is done.
+	Open func(r *http.Request) (*Player, error)
+	// BufferSize is how many verified bytes are held back before the response is committed, defaults to 4KB
+	BufferSize int
+}
+
+func (h *PlaintextHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p, err := h.Open(r)
+	if err != nil {
+		fmt.Printf("[player] Failed opening stream for %q: %v\n", r.URL.Path, err)
+		http.Error(w, "failed opening stream", http.StatusInternalServerError)
+		return
+	}
+	if c, ok := p.Source.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	hw := &heldWriter{w: w, size: h.BufferSize}
+	if hw.size <= 0 {
+		hw.size = defaultResponseBuffer
+	}
+	n, err := p.Stream(hw)
+	if err == nil {
+		if err = hw.commit(); err != nil {
+			fmt.Printf("[player] Failed writing %q: %v\n", r.URL.Path, err)
+		}
+		return
+	}
+
+	var verifyErr *VerificationError
+	if errors.As(err, &verifyErr) {
+		fmt.Printf("[player] Block %d of %q failed verification after %d bytes: %v\n", verifyErr.Block, r.URL.Path, n, verifyErr.Err)
+	} else {
+		fmt.Printf("[player] Failed streaming %q after %d bytes: %v\n", r.URL.Path, n, err)
+	}
+
+	if !hw.committed {
+		http.Error(w, "stream failed", http.StatusInternalServerError)
+		return
+	}
+	// the status has already been sent, abort so the client can't mistake the truncated body for the whole stream
+	panic(http.ErrAbortHandler)
+}
+
+// heldWriter holds back the start of a response, so it can still be replaced by an error
+type heldWriter struct {
+	w         http.ResponseWriter
+	size      int
+	buf       bytes.Buffer
+	committed bool
+}
+
+func (hw *heldWriter) Write(p []byte) (int, error) {
+	if hw.committed {
+		return hw.w.Write(p)
+	}
+	hw.buf.Write(p)
+	if hw.buf.Len() >= hw.size {
+		if err := hw.commit(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// commit sends the status and anything held back
+func (hw *heldWriter) commit() error {
+	if hw.committed {
+		return nil
+	}
+	hw.committed = true
+	hw.w.Header().Set("Content-Type", "application/octet-stream")
+	_, err := hw.w.Write(hw.buf.Bytes())
+	hw.buf = bytes.Buffer{}
+	return err
+}
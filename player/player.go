@@ -0,0 +1,223 @@
+package player
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"stealthybox.dev/go-hash-player/audit"
+	"stealthybox.dev/go-hash-player/decoder"
+)
+
+// Source serves the hashed blocks of a single stream.
+// Request 0 returns the initial hash, and each following request returns a block with the hash of the next one appended.
+// encoder.Encoder is a Source.
+type Source interface {
+	Request(requestNumber int64) ([]byte, error)
+}
+
+// HashOracle is a trusted source of the hashes anchoring a stream.
+// With an oracle, a Player only needs the blocks themselves from its Source, which can then be untrusted,
+// e.g. a CDN, since every block is still verified against the chain starting at the oracle's hash.
+// encoder.Encoder is a HashOracle.
+type HashOracle interface {
+	// InitialHash returns the hash of the first block, trusted in place of request 0 from the Source
+	InitialHash() ([]byte, error)
+	// HashAt returns the hash of blockIndex, used to resume or seek into the middle of a stream
+	HashAt(blockIndex int64) ([]byte, error)
+}
+
+// Player verifies and decodes a stream from its Source.
+type Player struct {
+	Source Source
+	// Oracle, if set, provides the hash anchoring the stream instead of the Source
+	Oracle HashOracle
+	// Sync makes StreamTo fsync the output file and its directory before returning successfully
+	Sync bool
+	// Audit records an audit.Verified event for each fully verified stream, or an audit.VerificationFailed event
+	// when a block fails verification or the stream is truncated
+	Audit *audit.Log
+	// StreamID identifies the stream in the Audit log
+	StreamID string
+	// Prefetch is how many blocks to request from the Source ahead of verification, 0 requests each block when needed
+	Prefetch int
+	// MemoryLimit caps the bytes a stream holds in memory, see Metrics for what's counted. 0 is unlimited.
+	// Prefetching holds back to stay under the limit, and the stream fails on a block that can't fit at all.
+	MemoryLimit int64
+	// Metrics, if set, is called with the stream's memory usage after each block is written
+	Metrics func(Metrics)
+}
+
+// VerificationError is returned when a block from the Source fails verification
+type VerificationError struct {
+	Block int64
+	Err   error
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("block %d: %v", e.Block, e.Err)
+}
+
+func (e *VerificationError) Unwrap() error {
+	return e.Err
+}
+
+// TruncatedError is returned when the Source ends a stream before its final block
+type TruncatedError struct {
+	// Block is the index of the first missing block
+	Block int64
+}
+
+func (e *TruncatedError) Error() string {
+	return fmt.Sprintf("stream ended before block %d, without reaching the final 0-hash", e.Block)
+}
+
+// syncFile is swapped out by tests to observe syncing
+var syncFile = (*os.File).Sync
+
+// Stream decodes every block from the Source into w, verifying each one against the hash chain.
+// It returns the number of decoded bytes written to w.
+// Blocks are written as they're verified, so on error w holds the verified prefix of the stream.
+// A Source that ends before the block carrying the 0-hash fails with a *TruncatedError.
+func (p *Player) Stream(w io.Writer) (n int64, err error) {
+	return p.StreamFrom(w, 0)
+}
+
+// StreamFrom is Stream starting at startBlock, rather than the first block.
+// Seeking past the first block needs an Oracle to anchor the chain at startBlock.
+func (p *Player) StreamFrom(w io.Writer, startBlock int64) (n int64, err error) {
+	hash, err := p.anchor(startBlock)
+	if err != nil {
+		return
+	}
+	s, err := decoder.NewStream(hash)
+	if err != nil {
+		return
+	}
+
+	// request n+1 returns block n
+	f := p.newFetcher(startBlock + 1)
+	defer f.Close()
+
+	for i := startBlock + 1; ; i++ {
+		hashedBlock, reqErr := f.Next()
+		if reqErr == io.EOF {
+			// only the final block carries the 0-hash, anything else means the Source cut the stream short
+			if !bytes.Equal(s.Hash(), make([]byte, 32)) {
+				truncErr := &TruncatedError{Block: i - 1}
+				p.auditFailure(startBlock, hash, n, truncErr.Block, truncErr)
+				return n, truncErr
+			}
+			p.auditVerified(startBlock, hash, n)
+			return
+		} else if reqErr != nil {
+			return n, reqErr
+		}
+
+		block, decodeErr := s.Next(hashedBlock)
+		if decodeErr != nil {
+			p.auditFailure(startBlock, hash, n, i-1, decodeErr)
+			return n, &VerificationError{Block: i - 1, Err: decodeErr}
+		}
+
+		written, wErr := w.Write(block)
+		n += int64(written)
+		if wErr != nil {
+			return n, wErr
+		}
+
+		if p.Metrics != nil {
+			p.Metrics(f.metrics(i - 1))
+		}
+		f.release(hashedBlock)
+	}
+}
+
+// anchor returns the trusted hash to verify startBlock against
+func (p *Player) anchor(startBlock int64) ([]byte, error) {
+	if p.Oracle != nil {
+		if startBlock == 0 {
+			return p.Oracle.InitialHash()
+		}
+		return p.Oracle.HashAt(startBlock)
+	}
+	if startBlock != 0 {
+		return nil, fmt.Errorf("Streaming from block %d requires an Oracle", startBlock)
+	}
+	return p.Source.Request(0)
+}
+
+// auditEvent starts an audit event for a stream anchored at startBlock
+func (p *Player) auditEvent(event string, startBlock int64, anchor []byte, n int64) audit.Event {
+	e := audit.Event{
+		Event:  event,
+		Stream: p.StreamID,
+		Bytes:  n,
+	}
+	if startBlock == 0 {
+		e.InitialHash = hex.EncodeToString(anchor)
+		return e
+	}
+	e.StartBlock = startBlock
+	e.Anchor = hex.EncodeToString(anchor)
+	// seeking always has an Oracle
+	if initialHash, err := p.Oracle.InitialHash(); err == nil {
+		e.InitialHash = hex.EncodeToString(initialHash)
+	}
+	return e
+}
+
+func (p *Player) auditVerified(startBlock int64, anchor []byte, n int64) {
+	if p.Audit == nil {
+		return
+	}
+	p.Audit.Record(p.auditEvent(audit.Verified, startBlock, anchor, n))
+}
+
+func (p *Player) auditFailure(startBlock int64, anchor []byte, n, blockIndex int64, err error) {
+	if p.Audit == nil {
+		return
+	}
+	e := p.auditEvent(audit.VerificationFailed, startBlock, anchor, n)
+	e.Block = &blockIndex
+	e.Error = err.Error()
+	p.Audit.Record(e)
+}
+
+// StreamTo decodes the stream into outfile, replacing any existing file.
+func (p *Player) StreamTo(outfile string) (err error) {
+	f, err := os.OpenFile(outfile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	if _, err = p.Stream(f); err != nil {
+		return
+	}
+
+	if p.Sync {
+		if err = syncFile(f); err != nil {
+			return
+		}
+		err = syncDir(filepath.Dir(outfile))
+	}
+	return
+}
+
+// syncDir makes a newly created file's directory entry durable
+func syncDir(dir string) (err error) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	return syncFile(d)
+}
@@ -0,0 +1,507 @@
+package player
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"stealthybox.dev/go-hash-player/audit"
+	"stealthybox.dev/go-hash-player/encoder"
+)
+
+var (
+	_ Source     = (*encoder.Encoder)(nil)
+	_ HashOracle = (*encoder.Encoder)(nil)
+)
+
+func newEncoder(t *testing.T, fileName string, blockSize int64) *encoder.Encoder {
+	t.Helper()
+	e := &encoder.Encoder{
+		FileName:  fileName,
+		BlockSize: blockSize,
+		CacheRoot: t.TempDir(),
+	}
+	if err := e.PreProcess(); err != nil {
+		t.Fatalf("failed preprocessing %q: %v", fileName, err)
+	}
+	t.Cleanup(func() { e.Close() })
+	return e
+}
+
+func TestStreamToSync(t *testing.T) {
+	var synced []string
+	syncFile = func(f *os.File) error {
+		synced = append(synced, f.Name())
+		return f.Sync()
+	}
+	defer func() { syncFile = (*os.File).Sync }()
+
+	want, err := os.ReadFile("../testdata/test_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sync := range []bool{false, true} {
+		synced = nil
+		outDir := t.TempDir()
+		outfile := filepath.Join(outDir, "out")
+		// existing contents are replaced
+		if err := os.WriteFile(outfile, bytes.Repeat([]byte{1}, 20000), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		p := Player{
+			Source: newEncoder(t, "../testdata/test_1", 1024),
+			Sync:   sync,
+		}
+		if err := p.StreamTo(outfile); err != nil {
+			t.Fatalf("sync=%v failed streaming: %v", sync, err)
+		}
+
+		got, err := os.ReadFile(outfile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("sync=%v output does not match input", sync)
+		}
+
+		if !sync && len(synced) != 0 {
+			t.Fatalf("expected no syncs, got: %v", synced)
+		}
+		if sync && (len(synced) != 2 || synced[0] != outfile || synced[1] != outDir) {
+			t.Fatalf("expected syncs of %q and %q, got: %v", outfile, outDir, synced)
+		}
+	}
+}
+
+// tamperSource flips a bit in the payload of one block
+type tamperSource struct {
+	Source
+	block int64
+}
+
+func (s tamperSource) Request(requestNumber int64) ([]byte, error) {
+	b, err := s.Source.Request(requestNumber)
+	if err == nil && requestNumber == s.block+1 {
+		b = append([]byte{}, b...)
+		b[0] ^= 1
+	}
+	return b, err
+}
+
+// truncateSource ends the stream early, before block
+type truncateSource struct {
+	Source
+	block int64
+}
+
+func (s truncateSource) Request(requestNumber int64) ([]byte, error) {
+	if requestNumber > s.block {
+		return nil, io.EOF
+	}
+	return s.Source.Request(requestNumber)
+}
+
+func TestStreamTruncated(t *testing.T) {
+	want, err := os.ReadFile("../testdata/test_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	p := Player{Source: truncateSource{Source: newEncoder(t, "../testdata/test_1", 1024), block: 3}}
+	n, err := p.Stream(&out)
+	var truncErr *TruncatedError
+	if !errors.As(err, &truncErr) || truncErr.Block != 3 {
+		t.Fatalf("expected the stream to be truncated before block 3, got: %v", err)
+	}
+	if n != 3*1024 || !bytes.Equal(out.Bytes(), want[:n]) {
+		t.Fatalf("expected the 3 verified blocks to be written, got %d bytes", n)
+	}
+
+	outfile := filepath.Join(t.TempDir(), "out")
+	if err := p.StreamTo(outfile); !errors.As(err, &truncErr) {
+		t.Fatalf("expected StreamTo to fail on a truncated stream, got: %v", err)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	var buf bytes.Buffer
+	log := audit.NewLog(&buf, 0)
+
+	e := newEncoder(t, "../testdata/test_1", 1024)
+	e.Audit = log
+	e.StreamID = "served-1"
+	initialHash, err := e.Request(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// both initial hash requests count towards the bytes served
+	ok := Player{Source: e, Audit: log, StreamID: "ok"}
+	if _, err := ok.Stream(io.Discard); err != nil {
+		t.Fatalf("failed streaming: %v", err)
+	}
+	untrusted := newEncoder(t, "../testdata/test_1", 1024)
+	bad := Player{Source: tamperSource{Source: untrusted, block: 4}, Audit: log, StreamID: "bad"}
+	if _, err := bad.Stream(io.Discard); err == nil {
+		t.Fatalf("expected verification failure")
+	}
+	short := Player{Source: truncateSource{Source: untrusted, block: 3}, Audit: log, StreamID: "short"}
+	if _, err := short.Stream(io.Discard); err == nil {
+		t.Fatalf("expected truncation failure")
+	}
+	seek := Player{Source: untrusted, Oracle: untrusted, Audit: log, StreamID: "seek"}
+	if _, err := seek.StreamFrom(io.Discard, 4); err != nil {
+		t.Fatalf("failed streaming from block 4: %v", err)
+	}
+	anchor, err := untrusted.HashAt(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// frames count towards the bytes served as well
+	frames := newEncoder(t, "../testdata/test_1", 1024)
+	frames.Audit = log
+	frames.StreamID = "frames-1"
+	for i := int64(1); ; i++ {
+		frame, err := frames.RequestFrame(i)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("failed on frame %d: %v", i, err)
+		}
+		frame.Close()
+	}
+
+	// a stream closed partway is still recorded, along with its failed requests
+	partial := newEncoder(t, "../testdata/test_1", 1024)
+	partial.Audit = log
+	partial.StreamID = "partial-1"
+	for i := int64(0); i <= 2; i++ {
+		if _, err := partial.Request(i); err != nil {
+			t.Fatalf("failed on request %d: %v", i, err)
+		}
+	}
+	if _, err := partial.RequestFrame(0); err == nil {
+		t.Fatalf("expected error for frame 0")
+	}
+	partial.Close()
+	// the next stream on the same encoder starts counting from 0
+	if _, err := partial.Request(0); err != nil {
+		t.Fatal(err)
+	}
+	partial.Close()
+
+	if err := log.Close(); err != nil {
+		t.Fatalf("failed closing audit log: %v", err)
+	}
+
+	var events []audit.Event
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var event audit.Event
+		if err := dec.Decode(&event); err != nil {
+			t.Fatalf("invalid audit line: %v", err)
+		}
+		if event.Time.IsZero() {
+			t.Fatalf("audit event is missing a timestamp: %+v", event)
+		}
+		if event.InitialHash != hex.EncodeToString(initialHash) {
+			t.Fatalf("audit event has the wrong initial hash: %+v", event)
+		}
+		events = append(events, event)
+	}
+
+	if len(events) != 8 {
+		t.Fatalf("expected 8 audit events, got: %+v", events)
+	}
+	closed, reopened := events[6], events[7]
+	if closed.Event != audit.Served || closed.Stream != "partial-1" || !closed.Partial ||
+		closed.Bytes != 32+2*(1024+32) || closed.Error == "" {
+		t.Fatalf("unexpected served event for a partial stream: %+v", closed)
+	}
+	if reopened.Event != audit.Served || !reopened.Partial || reopened.Bytes != 32 || reopened.Error != "" {
+		t.Fatalf("unexpected served event for the following stream: %+v", reopened)
+	}
+	served, verified, failed, truncated, seeked, framesServed := events[0], events[1], events[2], events[3], events[4], events[5]
+	if served.Event != audit.Served || served.Stream != "served-1" || served.Bytes != 2*32+10752+11*32 || served.Partial {
+		t.Fatalf("unexpected served event: %+v", served)
+	}
+	if verified.Event != audit.Verified || verified.Stream != "ok" || verified.Bytes != 10752 || verified.Anchor != "" {
+		t.Fatalf("unexpected verified event: %+v", verified)
+	}
+	if failed.Event != audit.VerificationFailed || failed.Stream != "bad" || failed.Block == nil || *failed.Block != 4 ||
+		failed.Bytes != 4*1024 || failed.Error == "" {
+		t.Fatalf("unexpected verification failure event: %+v", failed)
+	}
+	if truncated.Event != audit.VerificationFailed || truncated.Stream != "short" || truncated.Block == nil ||
+		*truncated.Block != 3 || truncated.Bytes != 3*1024 || truncated.Error == "" {
+		t.Fatalf("unexpected truncation event: %+v", truncated)
+	}
+	if seeked.Event != audit.Verified || seeked.Stream != "seek" || seeked.StartBlock != 4 ||
+		seeked.Anchor != hex.EncodeToString(anchor) || seeked.Bytes != 10752-4*1024 {
+		t.Fatalf("unexpected verified event after seeking: %+v", seeked)
+	}
+	if framesServed.Event != audit.Served || framesServed.Stream != "frames-1" || framesServed.Bytes != 11*(8+32)+10752 ||
+		framesServed.Partial {
+		t.Fatalf("unexpected served event for frames: %+v", framesServed)
+	}
+}
+
+func TestPlaintextHandler(t *testing.T) {
+	want, err := os.ReadFile("../testdata/test_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheRoot := t.TempDir()
+
+	srv := httptest.NewServer(&PlaintextHandler{
+		Open: func(r *http.Request) (*Player, error) {
+			e := &encoder.Encoder{
+				FileName:  "../testdata/test_1",
+				BlockSize: 1024,
+				CacheRoot: cacheRoot,
+			}
+			if err := e.PreProcess(); err != nil {
+				return nil, err
+			}
+			var src Source = e
+			if tamper := r.URL.Query().Get("tamper"); tamper != "" {
+				block, err := strconv.ParseInt(tamper, 10, 64)
+				if err != nil {
+					return nil, err
+				}
+				src = tamperSource{Source: e, block: block}
+			}
+			if truncate := r.URL.Query().Get("truncate"); truncate != "" {
+				block, err := strconv.ParseInt(truncate, 10, 64)
+				if err != nil {
+					return nil, err
+				}
+				src = truncateSource{Source: e, block: block}
+			}
+			return &Player{Source: src}, nil
+		},
+	})
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("failed reading plaintext: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(got, want) {
+		t.Fatalf("expected the original file with status 200, got status %d and %d bytes", resp.StatusCode, len(got))
+	}
+
+	// failing within the held back start of the response is reported by the status
+	for _, query := range []string{"tamper=0", "tamper=2", "truncate=3"} {
+		resp, err = http.Get(srv.URL + "?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusInternalServerError {
+			t.Fatalf("%s: expected status 500, got: %d", query, resp.StatusCode)
+		}
+	}
+
+	// failing past it resets the connection, leaving only the verified prefix
+	for _, query := range []string{"tamper=5", "truncate=8"} {
+		resp, err = http.Get(srv.URL + "?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err == nil {
+			t.Fatalf("%s: expected the response to be aborted, got %d bytes", query, len(got))
+		}
+		if !bytes.Equal(got, want[:len(got)]) || len(got) > 8*1024 {
+			t.Fatalf("%s: expected at most the verified blocks, got %d bytes", query, len(got))
+		}
+	}
+}
+
+// fakeOracle serves hashes it was handed up front
+type fakeOracle struct {
+	hashes [][]byte
+}
+
+func (o *fakeOracle) InitialHash() ([]byte, error) {
+	return o.HashAt(0)
+}
+
+func (o *fakeOracle) HashAt(blockIndex int64) ([]byte, error) {
+	if blockIndex < 0 || blockIndex >= int64(len(o.hashes)) {
+		return nil, fmt.Errorf("no hash for block %d", blockIndex)
+	}
+	return o.hashes[blockIndex], nil
+}
+
+func TestHashOracle(t *testing.T) {
+	want, err := os.ReadFile("../testdata/test_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trusted := newEncoder(t, "../testdata/test_1", 1024)
+	oracle := &fakeOracle{}
+	hashes := trusted.Hashes()
+	for {
+		hash, err := hashes.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		oracle.hashes = append(oracle.hashes, hash)
+	}
+
+	cdn := newEncoder(t, "../testdata/test_1", 1024)
+
+	var out bytes.Buffer
+	p := Player{Source: cdn, Oracle: oracle}
+	if _, err := p.Stream(&out); err != nil {
+		t.Fatalf("failed streaming: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), want) {
+		t.Fatalf("output does not match input")
+	}
+
+	// seeking anchors at the oracle's hash for the block
+	for _, start := range []int64{1, 4, 10} {
+		out.Reset()
+		if _, err := p.StreamFrom(&out, start); err != nil {
+			t.Fatalf("failed streaming from block %d: %v", start, err)
+		}
+		if !bytes.Equal(out.Bytes(), want[start*1024:]) {
+			t.Fatalf("output from block %d does not match input", start)
+		}
+	}
+	if _, err := p.StreamFrom(io.Discard, 11); err == nil {
+		t.Fatalf("expected error seeking past the last block")
+	}
+	if _, err := (&Player{Source: cdn}).StreamFrom(io.Discard, 4); err == nil {
+		t.Fatalf("expected error seeking without an oracle")
+	}
+
+	// a tampered block is caught, whether streaming from the start or seeking
+	var verifyErr *VerificationError
+	tampered := Player{Source: tamperSource{Source: cdn, block: 6}, Oracle: oracle}
+	if _, err := tampered.Stream(io.Discard); !errors.As(err, &verifyErr) || verifyErr.Block != 6 {
+		t.Fatalf("expected block 6 to fail verification, got: %v", err)
+	}
+	if _, err := tampered.StreamFrom(io.Discard, 4); !errors.As(err, &verifyErr) || verifyErr.Block != 6 {
+		t.Fatalf("expected block 6 to fail verification after seeking, got: %v", err)
+	}
+
+	// a Source cutting the stream short is caught too, even at a block boundary
+	var truncErr *TruncatedError
+	cut := Player{Source: truncateSource{Source: cdn, block: 3}, Oracle: oracle}
+	if n, err := cut.Stream(io.Discard); !errors.As(err, &truncErr) || truncErr.Block != 3 || n != 3*1024 {
+		t.Fatalf("expected the stream to be truncated before block 3, got %d bytes: %v", n, err)
+	}
+	cut = Player{Source: truncateSource{Source: cdn, block: 8}, Oracle: oracle}
+	if _, err := cut.StreamFrom(io.Discard, 4); !errors.As(err, &truncErr) || truncErr.Block != 8 {
+		t.Fatalf("expected the stream to be truncated before block 8 after seeking, got: %v", err)
+	}
+
+	// a Source serving a self-consistent chain for different content is rejected at the oracle's anchor
+	forged := Player{Source: newEncoder(t, "../testdata/test_0", 1024), Oracle: oracle}
+	if _, err := forged.Stream(io.Discard); !errors.As(err, &verifyErr) || verifyErr.Block != 0 {
+		t.Fatalf("expected forged stream to fail verification at block 0, got: %v", err)
+	}
+}
+
+// slowWriter gives a prefetching Player time to run ahead
+type slowWriter struct {
+	bytes.Buffer
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(time.Millisecond)
+	return w.Buffer.Write(p)
+}
+
+func TestMemoryLimit(t *testing.T) {
+	want, err := os.ReadFile("../testdata/test_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const hashedBlockSize = 1024 + 32
+
+	stream := func(limit int64) (peak Metrics, maxPrefetched int, err error) {
+		e := newEncoder(t, "../testdata/test_1", 1024)
+		if err := e.Warm(); err != nil {
+			t.Fatal(err)
+		}
+		var out slowWriter
+		p := Player{
+			Source:      e,
+			Prefetch:    8,
+			MemoryLimit: limit,
+			Metrics: func(m Metrics) {
+				if m.Memory < e.MemoryUsage() {
+					t.Errorf("block %d memory %d doesn't count the %d bytes of warmed hashes", m.Block, m.Memory, e.MemoryUsage())
+				}
+				if m.Memory > peak.Memory {
+					peak = m
+				}
+				if m.Prefetched > maxPrefetched {
+					maxPrefetched = m.Prefetched
+				}
+			},
+		}
+		if _, err = p.Stream(&out); err != nil {
+			return
+		}
+		if !bytes.Equal(out.Bytes(), want) {
+			t.Fatalf("limit %d: output does not match input", limit)
+		}
+		return
+	}
+
+	// unlimited, prefetching runs ahead of the slow writer
+	_, maxPrefetched, err := stream(0)
+	if err != nil {
+		t.Fatalf("failed streaming without a limit: %v", err)
+	}
+	if maxPrefetched <= 2 {
+		t.Fatalf("expected prefetching to run more than 2 blocks ahead without a limit, got: %d", maxPrefetched)
+	}
+
+	// room for the warmed hashes and 3 blocks: the one being written and 2 prefetched
+	limit := int64(11*32 + 3*hashedBlockSize)
+	peak, maxPrefetched, err := stream(limit)
+	if err != nil {
+		t.Fatalf("failed streaming with a limit: %v", err)
+	}
+	if peak.Memory > limit || peak.PeakMemory > limit {
+		t.Fatalf("expected memory to stay under %d, got: %+v", limit, peak)
+	}
+	if maxPrefetched > 2 {
+		t.Fatalf("expected prefetching to be throttled to 2 blocks, got: %d", maxPrefetched)
+	}
+
+	// a block that can't fit at all fails the stream
+	if _, _, err := stream(11*32 + hashedBlockSize - 1); err == nil {
+		t.Fatalf("expected error for a limit smaller than a block")
+	}
+}
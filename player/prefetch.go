@@ -0,0 +1,166 @@
+package player
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Metrics reports a stream's memory usage after each block
+type Metrics struct {
+	// Block is the index of the block just written
+	Block int64
+	// Memory is the bytes held by the stream: prefetched blocks, the block being decoded and
+	// any in-memory hashes the Source reports
+	Memory int64
+	// PeakMemory is the highest Memory seen so far in the stream
+	PeakMemory int64
+	// Prefetched is how many blocks were requested ahead of the one being decoded
+	Prefetched int
+}
+
+// memoryReporter is implemented by Sources holding memory for the stream, e.g. warmed hashes
+type memoryReporter interface {
+	MemoryUsage() int64
+}
+
+type fetched struct {
+	hashedBlock []byte
+	err         error
+}
+
+// fetcher requests blocks from a Source for a single stream, accounting for the memory they hold until released.
+// With a depth > 0 it requests up to depth blocks ahead from its own goroutine, holding back whenever another block
+// the size of the last one would take the stream over its limit.
+type fetcher struct {
+	src   Source
+	next  int64
+	depth int
+	// base is memory held by the Source for the whole stream
+	base  int64
+	limit int64
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	used   int64
+	peak   int64
+	closed bool
+
+	blocks chan fetched
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func (p *Player) newFetcher(startRequest int64) *fetcher {
+	f := &fetcher{
+		src:   p.Source,
+		next:  startRequest,
+		depth: p.Prefetch,
+		limit: p.MemoryLimit,
+	}
+	f.cond = sync.NewCond(&f.mu)
+	if m, ok := p.Source.(memoryReporter); ok {
+		f.base = m.MemoryUsage()
+	}
+	f.peak = f.base
+
+	if f.depth > 0 {
+		f.blocks = make(chan fetched, f.depth)
+		f.done = make(chan struct{})
+		f.wg.Add(1)
+		go f.prefetch()
+	}
+	return f
+}
+
+func (f *fetcher) prefetch() {
+	defer f.wg.Done()
+	var lastSize int64
+	for {
+		f.mu.Lock()
+		for !f.closed && f.used > 0 && f.limit > 0 && f.base+f.used+lastSize > f.limit {
+			f.cond.Wait()
+		}
+		closed := f.closed
+		f.mu.Unlock()
+		if closed {
+			return
+		}
+
+		hashedBlock, err := f.request()
+		select {
+		case f.blocks <- fetched{hashedBlock, err}:
+		case <-f.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+		lastSize = int64(len(hashedBlock))
+	}
+}
+
+// request fetches the next block and accounts for it
+func (f *fetcher) request() ([]byte, error) {
+	requestNumber := f.next
+	hashedBlock, err := f.src.Request(requestNumber)
+	if err != nil {
+		return nil, err
+	}
+	f.next++
+
+	size := int64(len(hashedBlock))
+	if f.limit > 0 && f.base+size > f.limit {
+		return nil, fmt.Errorf("block %d needs %d bytes, over the stream's memory limit of %d", requestNumber-1, f.base+size, f.limit)
+	}
+	f.mu.Lock()
+	f.used += size
+	if f.base+f.used > f.peak {
+		f.peak = f.base + f.used
+	}
+	f.mu.Unlock()
+	return hashedBlock, nil
+}
+
+// Next returns the next hashed block, which stays accounted for until it's released
+func (f *fetcher) Next() ([]byte, error) {
+	if f.depth == 0 {
+		return f.request()
+	}
+	b := <-f.blocks
+	return b.hashedBlock, b.err
+}
+
+// release stops accounting for a block returned by Next
+func (f *fetcher) release(hashedBlock []byte) {
+	f.mu.Lock()
+	f.used -= int64(len(hashedBlock))
+	f.mu.Unlock()
+	f.cond.Signal()
+}
+
+func (f *fetcher) metrics(blockIndex int64) Metrics {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m := Metrics{
+		Block:      blockIndex,
+		Memory:     f.base + f.used,
+		PeakMemory: f.peak,
+	}
+	if f.blocks != nil {
+		m.Prefetched = len(f.blocks)
+	}
+	return m
+}
+
+// Close stops prefetching, waiting for any in-flight request so the Source is no longer in use
+func (f *fetcher) Close() {
+	if f.depth == 0 {
+		return
+	}
+	f.mu.Lock()
+	f.closed = true
+	f.mu.Unlock()
+	f.cond.Broadcast()
+	close(f.done)
+	f.wg.Wait()
+}